@@ -0,0 +1,61 @@
+//go:build windows
+// +build windows
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cm
+
+import (
+	"k8s.io/utils/cpuset"
+)
+
+// windowsCPUSharedPool tracks the split between CPUs reserved for the system/kubelet and CPUs
+// available to BestEffort/Burstable workloads (the "shared" pool), for nodes that do not rely on
+// the OS to keep BestEffort/Burstable work off of pinned cores. Windows has no cpuset-inheritance
+// equivalent, so the kubelet has to compute and enforce this split itself rather than assuming
+// the container runtime or OS scheduler does it.
+type windowsCPUSharedPool struct {
+	// allocatableCPUs is every CPU on the node minus kube-reserved and system-reserved CPUs.
+	allocatableCPUs cpuset.CPUSet
+}
+
+// newWindowsCPUSharedPool builds a shared pool manager given the complete set of CPUs on the
+// node and the CPUs set aside for kube-reserved/system-reserved.
+func newWindowsCPUSharedPool(allCPUs, reservedCPUs cpuset.CPUSet) *windowsCPUSharedPool {
+	return &windowsCPUSharedPool{
+		allocatableCPUs: allCPUs.Difference(reservedCPUs),
+	}
+}
+
+// SharedPool returns the set of allocatable CPUs that are not currently under exclusive
+// allocation, i.e. the CPUs Burstable/BestEffort containers should be pinned to. defaultCPUSet is
+// the CPU manager's own live view of every CPU not currently handed out exclusively (its
+// "default" set on Linux serves the same purpose); intersecting it with allocatableCPUs is
+// enough to keep the shared pool in sync with every exclusive allocation and release the CPU
+// manager already tracks, without this type having to duplicate that bookkeeping or needing its
+// own release hook for container removal.
+//
+// This is computed lazily, on demand, rather than pushed: calling SharedPool only refreshes the
+// caller's own view of the pool. It does NOT reach out and update the affinity of other,
+// already-running Burstable/BestEffort containers when some other Guaranteed container's
+// exclusive allocation changes — those containers keep whatever affinity they were pinned to
+// until something re-resolves affinity for them (e.g. their own resize). Pushing updates to
+// every sibling container on an allocation change elsewhere would need a hook into the CPU
+// manager's allocate/reconcile path that doesn't exist yet.
+func (p *windowsCPUSharedPool) SharedPool(defaultCPUSet cpuset.CPUSet) cpuset.CPUSet {
+	return p.allocatableCPUs.Intersection(defaultCPUSet)
+}
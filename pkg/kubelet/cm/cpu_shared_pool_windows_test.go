@@ -0,0 +1,48 @@
+//go:build windows
+// +build windows
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cm
+
+import (
+	"testing"
+
+	"k8s.io/utils/cpuset"
+)
+
+func TestWindowsCPUSharedPool(t *testing.T) {
+	pool := newWindowsCPUSharedPool(cpuset.New(0, 1, 2, 3, 4, 5, 6, 7), cpuset.New(0, 1))
+
+	// No exclusive allocations yet: the CPU manager's default set is everything, so the shared
+	// pool is just the allocatable CPUs (node CPUs minus reserved).
+	if got := pool.SharedPool(cpuset.New(0, 1, 2, 3, 4, 5, 6, 7)); !got.Equals(cpuset.New(2, 3, 4, 5, 6, 7)) {
+		t.Errorf("expected shared pool to exclude reserved CPUs, got %v", got)
+	}
+
+	// CPUs 2 and 3 are now exclusively allocated, so the CPU manager's default set no longer
+	// includes them.
+	if got := pool.SharedPool(cpuset.New(0, 1, 4, 5, 6, 7)); !got.Equals(cpuset.New(4, 5, 6, 7)) {
+		t.Errorf("expected shared pool to exclude exclusively allocated CPUs, got %v", got)
+	}
+
+	// Once the CPU manager reports CPU 2 released (e.g. the exclusive container exited), the
+	// shared pool must grow back to include it, with no separate release call needed.
+	if got := pool.SharedPool(cpuset.New(0, 1, 2, 4, 5, 6, 7)); !got.Equals(cpuset.New(2, 4, 5, 6, 7)) {
+		t.Errorf("expected shared pool to reflect a released CPU, got %v", got)
+	}
+}
@@ -0,0 +1,39 @@
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cm
+
+import (
+	v1 "k8s.io/api/core/v1"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// InternalContainerLifecycle hooks into the container runtime's container lifecycle at the
+// points needed to apply kubelet-internal policy (e.g. CPU/memory manager affinity) that the
+// runtime itself doesn't know about. Implementations are platform-specific; non-Windows
+// platforms currently only need the PreCreateContainer hook.
+type InternalContainerLifecycle interface {
+	// PreCreateContainer is invoked before a container is created, allowing its resources to be
+	// mutated before they're sent to the runtime.
+	PreCreateContainer(pod *v1.Pod, container *v1.Container, containerConfig *runtimeapi.ContainerConfig) error
+
+	// UpdateContainerResources is invoked after an in-place pod vertical scaling resize has
+	// changed an already-running container's resources, allowing them to be mutated before
+	// they're sent to the runtime. pkg/kubelet/kuberuntime wraps it in
+	// updateContainerResourcesForInternalLifecycle; no code path in the in-place resize flow
+	// calls that wrapper, so resized containers keep the affinity set at creation.
+	UpdateContainerResources(pod *v1.Pod, container *v1.Container, containerResources *runtimeapi.ContainerResources) error
+}
@@ -0,0 +1,49 @@
+//go:build !windows
+// +build !windows
+
+/*
+Copyright 2020 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cm
+
+import (
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/kubernetes/pkg/kubelet/cm/cpumanager"
+	"k8s.io/kubernetes/pkg/kubelet/cm/memorymanager"
+	"k8s.io/utils/cpuset"
+)
+
+// internalContainerLifecycleImpl is a no-op InternalContainerLifecycle on platforms that don't
+// need to mutate container resources before they're sent to the runtime. Windows CPU group and
+// memory NUMA node affinity, the only current use of this interface, is Windows-specific.
+type internalContainerLifecycleImpl struct{}
+
+// newInternalContainerLifecycle returns a no-op InternalContainerLifecycle for this platform. It
+// takes the same arguments as its Windows counterpart, unused here, so callers don't need their
+// own build tags to construct one.
+func newInternalContainerLifecycle(cpuManager cpumanager.Manager, memoryManager memorymanager.Manager, recorder record.EventRecorder, allCPUs, reservedCPUs cpuset.CPUSet) *internalContainerLifecycleImpl {
+	return &internalContainerLifecycleImpl{}
+}
+
+func (i *internalContainerLifecycleImpl) PreCreateContainer(pod *v1.Pod, container *v1.Container, containerConfig *runtimeapi.ContainerConfig) error {
+	return nil
+}
+
+func (i *internalContainerLifecycleImpl) UpdateContainerResources(pod *v1.Pod, container *v1.Container, containerResources *runtimeapi.ContainerResources) error {
+	return nil
+}
@@ -21,17 +21,68 @@ package cm
 
 import (
 	"fmt"
+	"math/bits"
+	"sort"
 
 	v1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/sets"
 	utilfeature "k8s.io/apiserver/pkg/util/feature"
+	"k8s.io/client-go/tools/record"
 	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
 	"k8s.io/klog/v2"
 	kubefeatures "k8s.io/kubernetes/pkg/features"
+	"k8s.io/kubernetes/pkg/kubelet/cm/cpumanager"
+	"k8s.io/kubernetes/pkg/kubelet/cm/memorymanager"
+	"k8s.io/kubernetes/pkg/kubelet/cm/topologymanager"
 	"k8s.io/kubernetes/pkg/kubelet/winstats"
 	"k8s.io/utils/cpuset"
 )
 
+// internalContainerLifecycleImpl is the Windows implementation of InternalContainerLifecycle. It
+// consults the CPU manager and memory manager's allocations to pin container CPU group affinity
+// and memory NUMA node affinity, since the container runtime has no other way to learn them.
+type internalContainerLifecycleImpl struct {
+	cpuManager    cpumanager.Manager
+	memoryManager memorymanager.Manager
+
+	// recorder is used to surface a Case 3 CPU/memory manager affinity mismatch as a pod event.
+	// It may be nil, in which case mismatches are not recorded.
+	recorder record.EventRecorder
+
+	// cpuSharedPool tracks the CPUs available to Burstable/BestEffort containers. It is nil on
+	// nodes where the shared pool hasn't been configured, in which case such containers receive
+	// no affinity and float across every CPU on the node.
+	cpuSharedPool *windowsCPUSharedPool
+}
+
+// newInternalContainerLifecycle returns a Windows InternalContainerLifecycle that pins container
+// CPU group and memory NUMA node affinity from the given managers. allCPUs and reservedCPUs seed
+// the Burstable/BestEffort shared CPU pool; pass an empty allCPUs to leave the shared pool unset.
+func newInternalContainerLifecycle(cpuManager cpumanager.Manager, memoryManager memorymanager.Manager, recorder record.EventRecorder, allCPUs, reservedCPUs cpuset.CPUSet) *internalContainerLifecycleImpl {
+	var cpuSharedPool *windowsCPUSharedPool
+	if !allCPUs.IsEmpty() {
+		cpuSharedPool = newWindowsCPUSharedPool(allCPUs, reservedCPUs)
+	}
+
+	return &internalContainerLifecycleImpl{
+		cpuManager:    cpuManager,
+		memoryManager: memoryManager,
+		recorder:      recorder,
+		cpuSharedPool: cpuSharedPool,
+	}
+}
+
+// AffinityMismatch is the event reason recorded on a pod when the CPU manager and memory
+// manager selected NUMA affinities that disagree (Case 3): the container's CPU affinity is then
+// restricted to the CPUs the two selections share or, if they share none, falls back to one
+// manager's selection.
+const AffinityMismatch = "AffinityMismatch"
+
+// singleGroupAnnotation opts a pod out of multi-group CPU affinity: if set to "true" and the
+// container's allocation would otherwise span more than one Windows Processor Group, affinity
+// resolution fails instead of silently spreading the container across groups.
+const singleGroupAnnotation = "windows.kubernetes.io/single-group"
+
 func (i *internalContainerLifecycleImpl) PreCreateContainer(pod *v1.Pod, container *v1.Container, containerConfig *runtimeapi.ContainerConfig) error {
 	if !utilfeature.DefaultFeatureGate.Enabled(kubefeatures.WindowsCPUAndMemoryAffinity) {
 		return nil
@@ -39,6 +90,47 @@ func (i *internalContainerLifecycleImpl) PreCreateContainer(pod *v1.Pod, contain
 
 	klog.Info("PreCreateContainer for Windows")
 
+	finalCPUSet, numaNodes, err := i.resolveAffinity(pod, container)
+	if err != nil {
+		return err
+	}
+
+	return setWindowsAffinity(pod, containerConfig.Windows.Resources, finalCPUSet, numaNodes)
+}
+
+// UpdateContainerResources re-pins the Windows CPU group and memory NUMA node affinity of an
+// already-running container, for use after an in-place pod vertical scaling resize has changed
+// the CPU manager's or memory manager's allocation for the container, since PreCreateContainer
+// only runs once at container creation time. It only fills in containerResources; it sends
+// nothing to the runtime itself. Its only caller is the kuberuntime wrapper
+// updateContainerResourcesForInternalLifecycle, which no resize code path calls.
+func (i *internalContainerLifecycleImpl) UpdateContainerResources(pod *v1.Pod, container *v1.Container, containerResources *runtimeapi.ContainerResources) error {
+	if !utilfeature.DefaultFeatureGate.Enabled(kubefeatures.WindowsCPUAndMemoryAffinity) {
+		return nil
+	}
+
+	klog.Info("UpdateContainerResources for Windows")
+
+	finalCPUSet, numaNodes, err := i.resolveAffinity(pod, container)
+	if err != nil {
+		return err
+	}
+
+	// containerResources.Windows is only guaranteed to be populated once the kuberuntime resize
+	// path knows to fill it in; allocate it here rather than assume the caller always does, since
+	// this hook is meant to be reachable before every call site necessarily sets it.
+	if containerResources.Windows == nil {
+		containerResources.Windows = &runtimeapi.WindowsContainerResources{}
+	}
+
+	return setWindowsAffinity(pod, containerResources.Windows, finalCPUSet, numaNodes)
+}
+
+// resolveAffinity computes the CPU group affinity and memory NUMA node affinity that a
+// container should run with, based on the current allocations from the CPU manager and memory
+// manager. It is shared by PreCreateContainer and UpdateContainerResources so that both the
+// create-time and resize-time paths apply the same KEP logic.
+func (i *internalContainerLifecycleImpl) resolveAffinity(pod *v1.Pod, container *v1.Container) (map[int]struct{}, sets.Set[int], error) {
 	// retrieve CPU and NUMA affinity from CPU Manager and Memory Manager (if enabled)
 	var allocatedCPUs cpuset.CPUSet
 	if i.cpuManager != nil {
@@ -50,6 +142,12 @@ func (i *internalContainerLifecycleImpl) PreCreateContainer(pod *v1.Pod, contain
 		numaNodes = i.memoryManager.GetMemoryNUMANodes(pod, container)
 	}
 
+	// Pull the topology hint that drove each manager's allocation so that, if the two
+	// managers' selections disagree (Case 3 below), we know which one to fall back to instead
+	// of silently widening affinity to their union.
+	cpuHint := preferredHint(i.cpuManager, pod, container, "cpu")
+	memHint := preferredHint(i.memoryManager, pod, container, "memory")
+
 	// set affinity based on available managers
 	var finalCPUSet map[int]struct{}
 
@@ -62,7 +160,7 @@ func (i *internalContainerLifecycleImpl) PreCreateContainer(pod *v1.Pod, contain
 		for _, numaNode := range sets.List(numaNodes) {
 			affinity, err := winstats.GetCPUsforNUMANode(uint16(numaNode))
 			if err != nil {
-				return fmt.Errorf("failed to get CPUs for NUMA node %d: %v", numaNode, err)
+				return nil, nil, fmt.Errorf("failed to get CPUs for NUMA node %d: %v", numaNode, err)
 			}
 			allNumaNodeCPUs = append(allNumaNodeCPUs, *affinity)
 		}
@@ -71,20 +169,10 @@ func (i *internalContainerLifecycleImpl) PreCreateContainer(pod *v1.Pod, contain
 		cpuManagerAffinityCPUSet := computeCPUSet(convertToGroupAffinities(allocatedCPUs))
 		numaNodeAffinityCPUSet := computeCPUSet(allNumaNodeCPUs)
 
-		// Determine which set of CPUs to use using the following logic outlined in the KEP:
-		// Case 1: CPU manager selects more CPUs than those availble in the NUMA nodes selected by the memory manager
-		// Case 2: CPU manager selects fewer CPUs, and they all fall within the CPUs available in the NUMA nodes selected by the memory manager
-		// Case 3: CPU manager selects fewer CPUs, but some are outside of the CPUs available in the NUMA nodes selected by the memory manager
-
-		if len(cpuManagerAffinityCPUSet) > len(numaNodeAffinityCPUSet) {
-			// Case 1, use CPU manager selected CPUs
-			finalCPUSet = cpuManagerAffinityCPUSet
-		} else if subset(cpuManagerAffinityCPUSet, numaNodeAffinityCPUSet) {
-			// case 2, use CPU manager selected CPUs
-			finalCPUSet = cpuManagerAffinityCPUSet
-		} else {
-			// Case 3, merge CPU manager and memory manager selected CPUs
-			finalCPUSet = mergeSets(cpuManagerAffinityCPUSet, numaNodeAffinityCPUSet)
+		var mismatch bool
+		finalCPUSet, mismatch = resolveCPUSetForBothManagers(cpuManagerAffinityCPUSet, numaNodeAffinityCPUSet, cpuHint, memHint)
+		if mismatch {
+			i.recordAffinityMismatch(pod, container, cpuManagerAffinityCPUSet, numaNodeAffinityCPUSet)
 		}
 	} else if i.cpuManager != nil && !allocatedCPUs.IsEmpty() {
 		// Only CPU manager is enabled, use CPU manager selected CPUs
@@ -95,29 +183,254 @@ func (i *internalContainerLifecycleImpl) PreCreateContainer(pod *v1.Pod, contain
 		for _, numaNode := range sets.List(numaNodes) {
 			affinity, err := winstats.GetCPUsforNUMANode(uint16(numaNode))
 			if err != nil {
-				return fmt.Errorf("failed to get CPUs for NUMA node %d: %v", numaNode, err)
+				return nil, nil, fmt.Errorf("failed to get CPUs for NUMA node %d: %v", numaNode, err)
 			}
 			allNumaNodeCPUs = append(allNumaNodeCPUs, *affinity)
 		}
 		finalCPUSet = computeCPUSet(allNumaNodeCPUs)
 	}
 
+	// Burstable/BestEffort containers get no exclusive allocation from the CPU manager, so
+	// finalCPUSet is still nil here. Left unset, these containers would float across every CPU
+	// on the node, including ones pinned to Guaranteed workloads above, because Windows has no
+	// cpuset-inheritance mechanism to keep them off of reserved cores the way cgroups does on
+	// Linux. Pin them to the shared pool instead.
+	if finalCPUSet == nil && allocatedCPUs.IsEmpty() && i.cpuSharedPool != nil && i.cpuManager != nil {
+		// Ask the CPU manager for its own live default CPU set (every CPU not currently handed
+		// out exclusively) rather than tracking exclusive allocations ourselves, so the shared
+		// pool reflects releases (e.g. a Guaranteed container exiting) without this package
+		// needing its own release path.
+		finalCPUSet = computeCPUSet(convertToGroupAffinities(i.cpuSharedPool.SharedPool(i.cpuManager.State().GetDefaultCPUSet())))
+	}
+
+	return finalCPUSet, numaNodes, nil
+}
+
+// resolveCPUSetForBothManagers picks the final CPU set for a container when both the CPU manager
+// and memory manager made a selection, implementing the three cases from the KEP:
+//
+//	Case 1: CPU manager selects more CPUs than are available in the NUMA nodes selected by the memory manager
+//	Case 2: CPU manager selects fewer CPUs, and they all fall within the CPUs available in those NUMA nodes
+//	Case 3: CPU manager selects fewer CPUs, but some fall outside the CPUs available in those NUMA nodes
+//
+// In Case 3 the two managers disagree: affinity is restricted to the CPUs they have in common
+// instead of widened to their union, which would defeat the purpose of whichever manager lost
+// out. If they share no CPUs at all, the choice falls back to whichever manager's topology hint
+// was marked preferred, defaulting to the CPU manager's selection to match cases 1 and 2. mismatch
+// reports whether case 3 was hit, so the caller knows to record an event.
+//
+// This is a pure function, taking already-resolved CPU sets and hints rather than the managers
+// themselves, so the four cases can be table-tested directly.
+func resolveCPUSetForBothManagers(cpuManagerAffinityCPUSet, numaNodeAffinityCPUSet map[int]struct{}, cpuHint, memHint *topologymanager.TopologyHint) (finalCPUSet map[int]struct{}, mismatch bool) {
+	if len(cpuManagerAffinityCPUSet) > len(numaNodeAffinityCPUSet) {
+		// Case 1, use CPU manager selected CPUs
+		return cpuManagerAffinityCPUSet, false
+	}
+	if subset(cpuManagerAffinityCPUSet, numaNodeAffinityCPUSet) {
+		// Case 2, use CPU manager selected CPUs
+		return cpuManagerAffinityCPUSet, false
+	}
+
+	// Case 3
+	if intersection := intersect(cpuManagerAffinityCPUSet, numaNodeAffinityCPUSet); len(intersection) > 0 {
+		return intersection, true
+	}
+	if memHint != nil && memHint.Preferred && (cpuHint == nil || !cpuHint.Preferred) {
+		return numaNodeAffinityCPUSet, true
+	}
+	return cpuManagerAffinityCPUSet, true
+}
+
+// hintProvider is implemented by the CPU manager and memory manager; it is used here purely to
+// retrieve the topology hint that drove an allocation, not to request a new one.
+type hintProvider interface {
+	GetTopologyHints(pod *v1.Pod, container *v1.Container) map[string][]topologymanager.TopologyHint
+}
+
+// preferredHint returns the topology hint provider's preferred hint for the given resource, or
+// its first candidate if none is marked preferred. It returns nil if the provider is disabled or
+// produced no hints for this container.
+func preferredHint(provider hintProvider, pod *v1.Pod, container *v1.Container, resource string) *topologymanager.TopologyHint {
+	if provider == nil {
+		return nil
+	}
+
+	hints := provider.GetTopologyHints(pod, container)[resource]
+	if len(hints) == 0 {
+		return nil
+	}
+
+	for _, hint := range hints {
+		if hint.Preferred {
+			h := hint
+			return &h
+		}
+	}
+
+	h := hints[0]
+	return &h
+}
+
+// recordAffinityMismatch surfaces a Case 3 CPU/memory manager disagreement as a pod event so
+// operators can see when the two managers' NUMA affinity choices diverged.
+func (i *internalContainerLifecycleImpl) recordAffinityMismatch(pod *v1.Pod, container *v1.Container, cpuManagerAffinityCPUSet, numaNodeAffinityCPUSet map[int]struct{}) {
+	if i.recorder == nil {
+		return
+	}
+	i.recorder.Eventf(pod, v1.EventTypeWarning, AffinityMismatch,
+		"CPU manager and memory manager selected different NUMA affinity for container %s: cpu manager CPUs %v, memory manager CPUs %v",
+		container.Name, sortedCPUs(cpuManagerAffinityCPUSet), sortedCPUs(numaNodeAffinityCPUSet))
+}
+
+// sortedCPUs returns the CPU IDs in a set in ascending order, for deterministic log/event output.
+func sortedCPUs(cpuSet map[int]struct{}) []int {
+	cpus := make([]int, 0, len(cpuSet))
+	for cpu := range cpuSet {
+		cpus = append(cpus, cpu)
+	}
+	sort.Ints(cpus)
+	return cpus
+}
+
+// setWindowsAffinity sets the CPU group affinity and memory NUMA node affinity fields on a
+// WindowsContainerResources, shared by the create-time and update-time paths.
+func setWindowsAffinity(pod *v1.Pod, resources *runtimeapi.WindowsContainerResources, finalCPUSet map[int]struct{}, numaNodes sets.Set[int]) error {
 	// Set CPU group affinities in the container config
 	if finalCPUSet != nil {
-		var cpusToGroupAffinities []*runtimeapi.WindowsCpuGroupAffinity
-		for group, mask := range groupMasks(finalCPUSet) {
-			cpusToGroupAffinities = append(cpusToGroupAffinities, &runtimeapi.WindowsCpuGroupAffinity{
-				CpuGroup: uint32(group),
-				CpuMask:  uint64(mask),
-			})
+		cpusToGroupAffinities, err := sortedGroupAffinities(finalCPUSet)
+		if err != nil {
+			return err
+		}
+
+		if err := validateSingleGroup(pod, cpusToGroupAffinities); err != nil {
+			return err
+		}
+
+		resources.AffinityCpus = cpusToGroupAffinities
+		if len(cpusToGroupAffinities) > 1 {
+			resources.AffinityPolicy = groupAffinityPolicy(cpusToGroupAffinities)
 		}
-		containerConfig.Windows.Resources.AffinityCpus = cpusToGroupAffinities
 	}
 
-	// return nil if no CPUs were selected
+	// Set memory NUMA node affinity in the container config so that the runtime can pin
+	// working-set allocations to the same NUMA nodes the memory manager selected. This is
+	// independent of finalCPUSet since the memory manager's decision stands even when finalCPUSet
+	// holds CPUs outside those NUMA nodes (Case 1, or the Case 3 fallback to the CPU manager's
+	// selection).
+	//
+	// NOT YET DONE: AffinityMemoryNodes does not exist on runtimeapi.WindowsContainerResources
+	// today. Landing it needs a k8s.io/cri-api proto change (new field on WindowsContainerResources)
+	// and a vendor bump, plus a pkg/kubelet/kuberuntime read of the field back off
+	// containerConfig.Windows.Resources; none of that is included in this change, so this line
+	// does not compile against the real, current cri-api and the field is not actually sent to
+	// the runtime yet.
+	if numaNodes.Len() > 0 {
+		resources.AffinityMemoryNodes = memoryAffinityNodes(numaNodes)
+	}
+
 	return nil
 }
 
+// sortedGroupAffinities converts a set of CPU IDs into per-Processor-Group masks, ordered by
+// ascending group id so the runtime always sees a deterministic group order for a given
+// allocation. It also rejects any mask that would reference a processor beyond what
+// winstats.GetActiveProcessorCount reports as active for that group.
+func sortedGroupAffinities(cpuSet map[int]struct{}) ([]*runtimeapi.WindowsCpuGroupAffinity, error) {
+	affinities := orderedGroupAffinities(groupMasks(cpuSet))
+
+	for _, affinity := range affinities {
+		activeCount, err := winstats.GetActiveProcessorCount(uint16(affinity.CpuGroup))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get active processor count for group %d: %v", affinity.CpuGroup, err)
+		}
+		if highestSetBit(affinity.CpuMask) >= int(activeCount) {
+			return nil, fmt.Errorf("CPU affinity mask %#x for group %d references a processor beyond the group's %d active processors", affinity.CpuMask, affinity.CpuGroup, activeCount)
+		}
+	}
+	return affinities, nil
+}
+
+// orderedGroupAffinities converts group/mask pairs into a slice sorted by ascending group id.
+func orderedGroupAffinities(masks map[int]uint64) []*runtimeapi.WindowsCpuGroupAffinity {
+	groups := make([]int, 0, len(masks))
+	for group := range masks {
+		groups = append(groups, group)
+	}
+	sort.Ints(groups)
+
+	affinities := make([]*runtimeapi.WindowsCpuGroupAffinity, 0, len(groups))
+	for _, group := range groups {
+		affinities = append(affinities, &runtimeapi.WindowsCpuGroupAffinity{
+			CpuGroup: uint32(group),
+			CpuMask:  masks[group],
+		})
+	}
+	return affinities
+}
+
+// highestSetBit returns the index of the highest set bit in mask, or -1 if mask is zero.
+func highestSetBit(mask uint64) int {
+	bit := -1
+	for b := 0; b < 64; b++ {
+		if mask&(uint64(1)<<uint(b)) != 0 {
+			bit = b
+		}
+	}
+	return bit
+}
+
+// validateSingleGroup rejects a multi-group CPU allocation for a pod that opted out of spanning
+// Processor Groups via the singleGroupAnnotation.
+func validateSingleGroup(pod *v1.Pod, affinities []*runtimeapi.WindowsCpuGroupAffinity) error {
+	if len(affinities) <= 1 || pod.Annotations[singleGroupAnnotation] != "true" {
+		return nil
+	}
+
+	groups := make([]uint32, 0, len(affinities))
+	for _, affinity := range affinities {
+		groups = append(groups, affinity.CpuGroup)
+	}
+	return fmt.Errorf("pod %s/%s is annotated %s=true but its CPU allocation spans multiple processor groups %v", pod.Namespace, pod.Name, singleGroupAnnotation, groups)
+}
+
+// groupAffinityPolicy decides how the runtime should apply affinity across multiple Processor
+// Groups: if one group holds a strict majority of the allocated CPUs, the rest are a fallback
+// for the odd thread that doesn't fit; otherwise threads are round-robined across groups evenly.
+//
+// NOT YET DONE: this relies on a WindowsContainerResources.AffinityPolicy field and a
+// WindowsCpuGroupAffinityPolicy enum that do not exist on the real, current
+// runtimeapi (k8s.io/cri-api/pkg/apis/runtime/v1) — adding them for real needs a cri-api proto
+// change plus a vendor bump, which this series does not include, so this function's return value
+// does not compile against the real, current cri-api and is not actually sent to the runtime yet.
+func groupAffinityPolicy(affinities []*runtimeapi.WindowsCpuGroupAffinity) runtimeapi.WindowsCpuGroupAffinityPolicy {
+	total := 0
+	primaryCount := 0
+	for _, affinity := range affinities {
+		count := bits.OnesCount64(affinity.CpuMask)
+		total += count
+		if count > primaryCount {
+			primaryCount = count
+		}
+	}
+
+	// Require a strict majority: an even split gives the runtime no single group worth treating
+	// as primary, so it should round-robin threads across all of them instead.
+	if primaryCount*2 > total {
+		return runtimeapi.WindowsCpuGroupAffinityPolicy_PRIMARY_GROUP_WITH_FALLBACK
+	}
+	return runtimeapi.WindowsCpuGroupAffinityPolicy_ROUND_ROBIN
+}
+
+// memoryAffinityNodes converts a set of NUMA node IDs into a sorted slice suitable for
+// runtimeapi.WindowsContainerResources.AffinityMemoryNodes.
+func memoryAffinityNodes(numaNodes sets.Set[int]) []uint32 {
+	nodes := make([]uint32, 0, numaNodes.Len())
+	for _, numaNode := range sets.List(numaNodes) {
+		nodes = append(nodes, uint32(numaNode))
+	}
+	return nodes
+}
+
 // computeCPUSet returns a map of CPU IDs to an empty struct based on the provided group affinities
 func computeCPUSet(affinities []winstats.GROUP_AFFINITY) map[int]struct{} {
 	cpuSet := make(map[int]struct{})
@@ -139,16 +452,15 @@ func subset(set1, set2 map[int]struct{}) bool {
 	return true
 }
 
-// mergeSets combines two sets of CPU IDs
-func mergeSets(set1, set2 map[int]struct{}) map[int]struct{} {
-	mergedSet := make(map[int]struct{})
+// intersect returns the CPU IDs present in both set1 and set2
+func intersect(set1, set2 map[int]struct{}) map[int]struct{} {
+	intersection := make(map[int]struct{})
 	for k := range set1 {
-		mergedSet[k] = struct{}{}
-	}
-	for k := range set2 {
-		mergedSet[k] = struct{}{}
+		if _, ok := set2[k]; ok {
+			intersection[k] = struct{}{}
+		}
 	}
-	return mergedSet
+	return intersection
 }
 
 // convertToGroupAffinities converts a cpuset.CPUSet to a slice of winstats.GROUP_AFFINITY
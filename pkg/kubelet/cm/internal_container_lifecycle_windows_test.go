@@ -22,6 +22,11 @@ package cm
 import (
 	"testing"
 
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+	"k8s.io/kubernetes/pkg/kubelet/cm/topologymanager"
 	"k8s.io/kubernetes/pkg/kubelet/winstats"
 	"k8s.io/utils/cpuset"
 )
@@ -91,7 +96,7 @@ func TestSubset(t *testing.T) {
 	}
 }
 
-func TestMergeSets(t *testing.T) {
+func TestIntersect(t *testing.T) {
 	tests := []struct {
 		set1     map[int]struct{}
 		set2     map[int]struct{}
@@ -100,32 +105,27 @@ func TestMergeSets(t *testing.T) {
 		{
 			set1:     map[int]struct{}{1: {}, 2: {}},
 			set2:     map[int]struct{}{3: {}, 4: {}},
-			expected: map[int]struct{}{1: {}, 2: {}, 3: {}, 4: {}},
+			expected: map[int]struct{}{},
 		},
 		{
 			set1:     map[int]struct{}{1: {}, 2: {}},
 			set2:     map[int]struct{}{2: {}, 3: {}},
-			expected: map[int]struct{}{1: {}, 2: {}, 3: {}},
+			expected: map[int]struct{}{2: {}},
 		},
 		{
 			set1:     map[int]struct{}{},
 			set2:     map[int]struct{}{1: {}, 2: {}},
-			expected: map[int]struct{}{1: {}, 2: {}},
-		},
-		{
-			set1:     map[int]struct{}{1: {}, 2: {}},
-			set2:     map[int]struct{}{},
-			expected: map[int]struct{}{1: {}, 2: {}},
+			expected: map[int]struct{}{},
 		},
 		{
-			set1:     map[int]struct{}{},
-			set2:     map[int]struct{}{},
-			expected: map[int]struct{}{},
+			set1:     map[int]struct{}{1: {}, 2: {}, 3: {}},
+			set2:     map[int]struct{}{1: {}, 2: {}, 3: {}},
+			expected: map[int]struct{}{1: {}, 2: {}, 3: {}},
 		},
 	}
 
 	for _, test := range tests {
-		result := mergeSets(test.set1, test.set2)
+		result := intersect(test.set1, test.set2)
 		if len(result) != len(test.expected) {
 			t.Errorf("expected length %v, but got length %v", len(test.expected), len(result))
 		}
@@ -137,6 +137,150 @@ func TestMergeSets(t *testing.T) {
 	}
 }
 
+func TestResolveCPUSetForBothManagers(t *testing.T) {
+	preferred := topologymanager.TopologyHint{Preferred: true}
+	notPreferred := topologymanager.TopologyHint{Preferred: false}
+
+	cpuSet := map[int]struct{}{0: {}, 1: {}, 2: {}}
+	numaSet := map[int]struct{}{0: {}, 1: {}}
+	disjointNumaSet := map[int]struct{}{4: {}, 5: {}, 6: {}}
+	overlappingNumaSet := map[int]struct{}{1: {}, 2: {}, 3: {}}
+
+	tests := []struct {
+		name                     string
+		cpuManagerAffinityCPUSet map[int]struct{}
+		numaNodeAffinityCPUSet   map[int]struct{}
+		cpuHint                  *topologymanager.TopologyHint
+		memHint                  *topologymanager.TopologyHint
+		expected                 map[int]struct{}
+		expectMismatch           bool
+	}{
+		{
+			name:                     "case 1: CPU manager selects more CPUs than the NUMA nodes provide",
+			cpuManagerAffinityCPUSet: cpuSet,
+			numaNodeAffinityCPUSet:   numaSet,
+			expected:                 cpuSet,
+			expectMismatch:           false,
+		},
+		{
+			name:                     "case 2: CPU manager's CPUs are a subset of the NUMA nodes' CPUs",
+			cpuManagerAffinityCPUSet: numaSet,
+			numaNodeAffinityCPUSet:   cpuSet,
+			expected:                 numaSet,
+			expectMismatch:           false,
+		},
+		{
+			name:                     "case 3, intersecting: managers disagree but share some CPUs",
+			cpuManagerAffinityCPUSet: cpuSet,
+			numaNodeAffinityCPUSet:   overlappingNumaSet,
+			expected:                 map[int]struct{}{1: {}, 2: {}},
+			expectMismatch:           true,
+		},
+		{
+			name:                     "case 3, disjoint: falls back to the CPU manager's selection when neither hint is preferred",
+			cpuManagerAffinityCPUSet: cpuSet,
+			numaNodeAffinityCPUSet:   disjointNumaSet,
+			cpuHint:                  &notPreferred,
+			memHint:                  &notPreferred,
+			expected:                 cpuSet,
+			expectMismatch:           true,
+		},
+		{
+			name:                     "case 3, disjoint: falls back to the memory manager's selection when only its hint is preferred",
+			cpuManagerAffinityCPUSet: cpuSet,
+			numaNodeAffinityCPUSet:   disjointNumaSet,
+			cpuHint:                  &notPreferred,
+			memHint:                  &preferred,
+			expected:                 disjointNumaSet,
+			expectMismatch:           true,
+		},
+		{
+			name:                     "case 3, disjoint: falls back to the CPU manager's selection when both hints are preferred",
+			cpuManagerAffinityCPUSet: cpuSet,
+			numaNodeAffinityCPUSet:   disjointNumaSet,
+			cpuHint:                  &preferred,
+			memHint:                  &preferred,
+			expected:                 cpuSet,
+			expectMismatch:           true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, mismatch := resolveCPUSetForBothManagers(test.cpuManagerAffinityCPUSet, test.numaNodeAffinityCPUSet, test.cpuHint, test.memHint)
+			if mismatch != test.expectMismatch {
+				t.Errorf("expected mismatch %v, got %v", test.expectMismatch, mismatch)
+			}
+			if len(result) != len(test.expected) {
+				t.Errorf("expected length %v, but got length %v", len(test.expected), len(result))
+			}
+			for key := range test.expected {
+				if _, exists := result[key]; !exists {
+					t.Errorf("expected key %v to be in result", key)
+				}
+			}
+		})
+	}
+}
+
+func TestPreferredHint(t *testing.T) {
+	preferred := topologymanager.TopologyHint{Preferred: true}
+	notPreferred := topologymanager.TopologyHint{Preferred: false}
+
+	tests := []struct {
+		name     string
+		provider hintProvider
+		expected *topologymanager.TopologyHint
+	}{
+		{
+			name:     "no provider",
+			provider: nil,
+			expected: nil,
+		},
+		{
+			name:     "no hints",
+			provider: fakeHintProvider{},
+			expected: nil,
+		},
+		{
+			name:     "returns the preferred hint",
+			provider: fakeHintProvider{hints: []topologymanager.TopologyHint{notPreferred, preferred}},
+			expected: &preferred,
+		},
+		{
+			name:     "falls back to the first candidate when none are preferred",
+			provider: fakeHintProvider{hints: []topologymanager.TopologyHint{notPreferred, notPreferred}},
+			expected: &notPreferred,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result := preferredHint(test.provider, nil, nil, "cpu")
+			if test.expected == nil {
+				if result != nil {
+					t.Errorf("expected nil hint, got %v", result)
+				}
+				return
+			}
+			if result == nil || *result != *test.expected {
+				t.Errorf("expected hint %v, got %v", test.expected, result)
+			}
+		})
+	}
+}
+
+type fakeHintProvider struct {
+	hints []topologymanager.TopologyHint
+}
+
+func (f fakeHintProvider) GetTopologyHints(pod *v1.Pod, container *v1.Container) map[string][]topologymanager.TopologyHint {
+	if len(f.hints) == 0 {
+		return nil
+	}
+	return map[string][]topologymanager.TopologyHint{"cpu": f.hints}
+}
+
 func TestConvertToGroupAffinities(t *testing.T) {
 	tests := []struct {
 		cpuSet   cpuset.CPUSet
@@ -180,6 +324,160 @@ func TestConvertToGroupAffinities(t *testing.T) {
 	}
 }
 
+func TestMemoryAffinityNodes(t *testing.T) {
+	tests := []struct {
+		numaNodes sets.Set[int]
+		expected  []uint32
+	}{
+		{
+			numaNodes: sets.New[int](0),
+			expected:  []uint32{0},
+		},
+		{
+			numaNodes: sets.New[int](1, 0),
+			expected:  []uint32{0, 1},
+		},
+		{
+			numaNodes: sets.New[int](),
+			expected:  []uint32{},
+		},
+	}
+
+	for _, test := range tests {
+		result := memoryAffinityNodes(test.numaNodes)
+		if len(result) != len(test.expected) {
+			t.Errorf("expected length %v, but got length %v", len(test.expected), len(result))
+		}
+		for i, expectedNode := range test.expected {
+			if result[i] != expectedNode {
+				t.Errorf("expected node %v at index %v, but got %v", expectedNode, i, result[i])
+			}
+		}
+	}
+}
+
+func TestOrderedGroupAffinities(t *testing.T) {
+	// A synthetic 128-CPU, 2-group topology: 64 CPUs exclusively allocated in group 1 and a
+	// handful in group 0.
+	masks := map[int]uint64{
+		1: 0xFFFFFFFFFFFFFFFF,
+		0: 0b1111,
+	}
+
+	affinities := orderedGroupAffinities(masks)
+
+	if len(affinities) != 2 {
+		t.Fatalf("expected 2 group affinities, got %d", len(affinities))
+	}
+	if affinities[0].CpuGroup != 0 || affinities[1].CpuGroup != 1 {
+		t.Errorf("expected affinities ordered by ascending group id, got groups %d, %d", affinities[0].CpuGroup, affinities[1].CpuGroup)
+	}
+	if affinities[0].CpuMask != 0b1111 || affinities[1].CpuMask != 0xFFFFFFFFFFFFFFFF {
+		t.Errorf("expected masks to follow their group, got %#x, %#x", affinities[0].CpuMask, affinities[1].CpuMask)
+	}
+}
+
+func TestHighestSetBit(t *testing.T) {
+	tests := []struct {
+		mask     uint64
+		expected int
+	}{
+		{mask: 0, expected: -1},
+		{mask: 0b1, expected: 0},
+		{mask: 0b1000, expected: 3},
+		{mask: 0xFFFFFFFFFFFFFFFF, expected: 63},
+	}
+
+	for _, test := range tests {
+		if result := highestSetBit(test.mask); result != test.expected {
+			t.Errorf("highestSetBit(%#x) = %d; expected %d", test.mask, result, test.expected)
+		}
+	}
+}
+
+func TestGroupAffinityPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		affinities []*runtimeapi.WindowsCpuGroupAffinity
+		expected   runtimeapi.WindowsCpuGroupAffinityPolicy
+	}{
+		{
+			// Synthetic 128-CPU, 2-group topology: the container has 64 CPUs in group 1, 4 in group 0. Group 1
+			// holds a strict majority, so the runtime should pin to it with group 0 as fallback.
+			name: "one group holds a strict majority",
+			affinities: []*runtimeapi.WindowsCpuGroupAffinity{
+				{CpuGroup: 0, CpuMask: 0b1111},
+				{CpuGroup: 1, CpuMask: 0xFFFFFFFFFFFFFFFF},
+			},
+			expected: runtimeapi.WindowsCpuGroupAffinityPolicy_PRIMARY_GROUP_WITH_FALLBACK,
+		},
+		{
+			name: "groups are evenly split",
+			affinities: []*runtimeapi.WindowsCpuGroupAffinity{
+				{CpuGroup: 0, CpuMask: 0xFFFFFFFFFFFFFFFF},
+				{CpuGroup: 1, CpuMask: 0xFFFFFFFFFFFFFFFF},
+			},
+			expected: runtimeapi.WindowsCpuGroupAffinityPolicy_ROUND_ROBIN,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if result := groupAffinityPolicy(test.affinities); result != test.expected {
+				t.Errorf("expected policy %v, got %v", test.expected, result)
+			}
+		})
+	}
+}
+
+func TestValidateSingleGroup(t *testing.T) {
+	multiGroup := []*runtimeapi.WindowsCpuGroupAffinity{
+		{CpuGroup: 0, CpuMask: 0b1111},
+		{CpuGroup: 1, CpuMask: 0b1111},
+	}
+	singleGroup := []*runtimeapi.WindowsCpuGroupAffinity{
+		{CpuGroup: 0, CpuMask: 0b1111},
+	}
+
+	tests := []struct {
+		name        string
+		pod         *v1.Pod
+		affinities  []*runtimeapi.WindowsCpuGroupAffinity
+		expectError bool
+	}{
+		{
+			name:        "annotated pod with multi-group allocation is rejected",
+			pod:         &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Annotations: map[string]string{singleGroupAnnotation: "true"}}},
+			affinities:  multiGroup,
+			expectError: true,
+		},
+		{
+			name:        "annotated pod with single-group allocation is allowed",
+			pod:         &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Annotations: map[string]string{singleGroupAnnotation: "true"}}},
+			affinities:  singleGroup,
+			expectError: false,
+		},
+		{
+			name:        "un-annotated pod with multi-group allocation is allowed",
+			pod:         &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default"}},
+			affinities:  multiGroup,
+			expectError: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateSingleGroup(test.pod, test.affinities)
+			if test.expectError && err == nil {
+				t.Errorf("expected an error, got nil")
+			}
+			if !test.expectError && err != nil {
+				t.Errorf("expected no error, got %v", err)
+			}
+		})
+	}
+}
+
 func TestGroupMasks(t *testing.T) {
 	tests := []struct {
 		cpuSet   map[int]struct{}
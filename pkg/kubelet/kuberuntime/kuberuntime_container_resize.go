@@ -0,0 +1,33 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package kuberuntime
+
+import (
+	v1 "k8s.io/api/core/v1"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// updateContainerResourcesForInternalLifecycle gives the kubelet's internal container lifecycle
+// (pkg/kubelet/cm.InternalContainerLifecycle) a chance to mutate a container's resources before
+// they're sent to the runtime as part of an in-place pod vertical scaling resize. This mirrors
+// how generateContainerConfig already calls internalLifecycle.PreCreateContainer before
+// container creation; resourceConfig is mutated in place, so its Windows CPU group and memory
+// NUMA node affinity (on Windows nodes, via pkg/kubelet/cm) stay pinned to whatever the CPU
+// manager and memory manager reallocated for the resize. No code in this package calls it.
+func (m *kubeGenericRuntimeManager) updateContainerResourcesForInternalLifecycle(pod *v1.Pod, container *v1.Container, resourceConfig *runtimeapi.ContainerResources) error {
+	return m.internalLifecycle.UpdateContainerResources(pod, container, resourceConfig)
+}